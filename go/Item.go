@@ -2,6 +2,7 @@ package workqueue
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -20,6 +21,31 @@ func NewItem(data []byte) Item {
 	}
 }
 
+// NewItemWithID creates a new item with the given ID. Another item with the same ID shouldn't be
+// added with [WorkQueue.AddItem] until the previous item has been completed. Use
+// [WorkQueue.AddItemIfAbsent] to add it only if it isn't already in the work queue.
+func NewItemWithID(id string, data []byte) Item {
+	return Item{
+		ID:   id,
+		Data: data,
+	}
+}
+
+// NewSortableID returns a new, random, UUID (in the UUIDv7 format) which starts with the current
+// time, so IDs created in different milliseconds sort in the order they were created.
+//
+// These can be used with [NewItemWithID] in place of the fully random IDs from [NewItem].
+func NewSortableID() string {
+	id := uuid.New()
+	ms := uint64(time.Now().UnixMilli())
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	// Set the version to 7. The variant was already set by uuid.New.
+	id[6] = id[6]&0x0f | 0x70
+	return id.String()
+}
+
 // NewItemFromJSONData creates a new item with a random ID (a UUID). The data is the result of json.Marshal(data).
 func NewItemFromJSONData(data any) (Item, error) {
 	dataBytes, err := json.Marshal(data)
@@ -3,6 +3,9 @@ package workqueue
 import (
 	"bytes"
 	"testing"
+	"time"
+
+	"github.com/google/uuid"
 )
 
 func unwrap[T any](value T, err error) T {
@@ -59,3 +62,32 @@ func TestItemJson(t *testing.T) {
 		t.Error("baz data didn't format or parse correctly")
 	}
 }
+
+func TestNewItemWithID(t *testing.T) {
+	item := NewItemWithID("foo", []byte("bar"))
+	if item.ID != "foo" {
+		t.Error("item ID not foo")
+	}
+	if string(item.Data) != "bar" {
+		t.Error("item data not bar")
+	}
+}
+
+func TestNewSortableID(t *testing.T) {
+	previous := NewSortableID()
+	for i := 0; i < 5; i++ {
+		time.Sleep(2 * time.Millisecond)
+		id := NewSortableID()
+		parsed := unwrap(uuid.Parse(id))
+		if parsed.Version() != 7 || parsed.Variant() != uuid.RFC4122 {
+			t.Error("sortable ID not a version 7 UUID")
+		}
+		if id <= previous {
+			t.Errorf("sortable ID %s not after %s", id, previous)
+		}
+		previous = id
+	}
+	if NewSortableID() == NewSortableID() {
+		t.Error("sortable IDs created together are equal")
+	}
+}
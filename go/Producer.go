@@ -0,0 +1,158 @@
+package workqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ProducerOptions configures when a [Producer] flushes its buffered items.
+type ProducerOptions struct {
+	// BatchSize is the number of buffered items at which a batch is flushed. Defaults to 100.
+	BatchSize int
+	// FlushInterval is how often buffered items are flushed, even if there are fewer than
+	// BatchSize of them. Defaults to 100ms.
+	FlushInterval time.Duration
+	// OnDelivery, if not nil, is called after each batch has been flushed, with the items in the
+	// batch and the error (if any) from adding them. It's called from the producer's goroutine, so
+	// shouldn't block for long.
+	//
+	// Whether or not OnDelivery is set, the first error from any batch is kept until it's returned
+	// by the next call to [Producer.Flush] or [Producer.Close].
+	OnDelivery func(items []Item, err error)
+}
+
+// Producer adds items to a work queue asynchronously, in pipelined batches. This is much faster
+// than calling [WorkQueue.AddItem] for each item when adding many small items.
+//
+// [Producer.Close] must be called to flush the remaining items and stop the producer's goroutine.
+type Producer struct {
+	workQueue     *WorkQueue
+	db            *redis.Client
+	options       ProducerOptions
+	items         chan Item
+	flushRequests chan chan error
+	closeOnce     sync.Once
+	// done is closed once the producer's goroutine has stopped, after which closeErr is set.
+	done     chan struct{}
+	closeErr error
+}
+
+// NewProducer creates a producer adding items to workQueue, and starts its goroutine. ctx is used
+// for all the redis commands the producer runs.
+func NewProducer(ctx context.Context, db *redis.Client, workQueue *WorkQueue, options ProducerOptions) *Producer {
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = 100 * time.Millisecond
+	}
+	producer := &Producer{
+		workQueue:     workQueue,
+		db:            db,
+		options:       options,
+		items:         make(chan Item, options.BatchSize),
+		flushRequests: make(chan chan error),
+		done:          make(chan struct{}),
+	}
+	go producer.run(ctx)
+	return producer
+}
+
+// AddItem buffers an item to be added to the work queue. It only blocks if the producer is already
+// a full batch behind.
+//
+// AddItem must not be called after, or concurrently with, [Producer.Close].
+func (producer *Producer) AddItem(item Item) {
+	producer.items <- item
+}
+
+// Flush adds all the items buffered so far to the work queue. It returns the first error from any
+// batch flushed since the previous call to Flush, including ones flushed in the background, so a nil
+// error means every item added since then has been added to the work queue.
+func (producer *Producer) Flush() error {
+	reply := make(chan error)
+	select {
+	case producer.flushRequests <- reply:
+		return <-reply
+	case <-producer.done:
+		return producer.closeErr
+	}
+}
+
+// Close flushes the remaining items, stops the producer's goroutine and returns the first error
+// from any batch flushed since the last call to [Producer.Flush]. Calling Close more than once is
+// safe, and returns the same error.
+func (producer *Producer) Close() error {
+	producer.closeOnce.Do(func() { close(producer.items) })
+	<-producer.done
+	return producer.closeErr
+}
+
+func (producer *Producer) run(ctx context.Context) {
+	defer close(producer.done)
+	ticker := time.NewTicker(producer.options.FlushInterval)
+	defer ticker.Stop()
+
+	// err is the first error from any batch since the last Flush.
+	var err error
+	batch := make([]Item, 0, producer.options.BatchSize)
+	// flushBatch flushes the batch, keeping the error if it's the first.
+	flushBatch := func() {
+		if len(batch) == 0 {
+			return
+		}
+		flushErr := producer.addBatch(ctx, batch)
+		if producer.options.OnDelivery != nil {
+			producer.options.OnDelivery(batch, flushErr)
+		}
+		if err == nil {
+			err = flushErr
+		}
+		batch = make([]Item, 0, producer.options.BatchSize)
+	}
+	// add appends an item to the batch, flushing it if it's full.
+	add := func(item Item) {
+		batch = append(batch, item)
+		if len(batch) >= producer.options.BatchSize {
+			flushBatch()
+		}
+	}
+
+	for {
+		select {
+		case item, ok := <-producer.items:
+			if !ok {
+				flushBatch()
+				producer.closeErr = err
+				return
+			}
+			add(item)
+		case <-ticker.C:
+			flushBatch()
+		case reply := <-producer.flushRequests:
+			// Items added before Flush was called may still be waiting in the channel, so take
+			// those first. Only take as many as were waiting when Flush was called, otherwise
+			// Flush would never return while other goroutines keep adding items.
+			for n := len(producer.items); n > 0; n-- {
+				add(<-producer.items)
+			}
+			flushBatch()
+			reply <- err
+			err = nil
+		}
+	}
+}
+
+// addBatch adds a batch of items to the work queue in a single pipeline.
+func (producer *Producer) addBatch(ctx context.Context, items []Item) error {
+	_, err := producer.db.Pipelined(ctx, func(pipeline redis.Pipeliner) error {
+		for _, item := range items {
+			producer.workQueue.AddItemToPipeline(ctx, pipeline, item)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,268 @@
+package workqueue
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is a minimal redis server, so the producer can be tested without a real one. It replies
+// OK to every command, counting LPUSHes, or replies with an error to everything while failing is
+// set.
+type fakeRedis struct {
+	pushed  atomic.Int64
+	failing atomic.Bool
+}
+
+// newFakeRedis starts a fake redis server, returning it and a client connected to it.
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	listener := unwrap(net.Listen("tcp", "127.0.0.1:0"))
+	server := &fakeRedis{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	db := redis.NewClient(&redis.Options{
+		Addr: listener.Addr().String(),
+	})
+	t.Cleanup(func() {
+		db.Close()
+		listener.Close()
+	})
+	return server, db
+}
+
+func (server *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		command, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		var reply string
+		switch {
+		case command == "HELLO":
+			// Make the client fall back to RESP2.
+			reply = "-ERR unknown command 'HELLO'\r\n"
+		case server.failing.Load():
+			reply = "-ERR fake failure\r\n"
+		case command == "LPUSH":
+			server.pushed.Add(1)
+			reply = ":1\r\n"
+		default:
+			reply = "+OK\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a RESP command, returning its name in upper case.
+func readCommand(reader *bufio.Reader) (string, error) {
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimSuffix(line, "\r\n"), err
+	}
+	line, err := readLine()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return "", fmt.Errorf("unexpected line %q", line)
+	}
+	args, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return "", err
+	}
+	var command string
+	for i := 0; i < args; i++ {
+		line, err := readLine()
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return "", fmt.Errorf("unexpected line %q", line)
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		arg := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return "", err
+		}
+		if i == 0 {
+			command = strings.ToUpper(string(arg[:length]))
+		}
+	}
+	return command, nil
+}
+
+// within fails the test if f doesn't return within timeout.
+func within(t *testing.T, timeout time.Duration, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal(what, "didn't return within", timeout)
+	}
+}
+
+func TestProducerFlushWhileAdding(t *testing.T) {
+	server, db := newFakeRedis(t)
+	workQueue := NewWorkQueue(KeyPrefix("producer_test"))
+	producer := NewProducer(context.Background(), db, &workQueue, ProducerOptions{
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+	})
+
+	var added atomic.Int64
+	stop := make(chan struct{})
+	var adders sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		adders.Add(1)
+		go func() {
+			defer adders.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					producer.AddItem(NewItem([]byte("flush")))
+					added.Add(1)
+				}
+			}
+		}()
+	}
+
+	// Flush must return, even though items keep being added.
+	time.Sleep(10 * time.Millisecond)
+	within(t, 3*time.Second, "Flush", func() {
+		if err := producer.Flush(); err != nil {
+			t.Error(err)
+		}
+	})
+	close(stop)
+	adders.Wait()
+
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if server.pushed.Load() != added.Load() {
+		t.Errorf("%d items added, %d pushed", added.Load(), server.pushed.Load())
+	}
+}
+
+func TestProducerFlushAndCloseRace(t *testing.T) {
+	server, db := newFakeRedis(t)
+	workQueue := NewWorkQueue(KeyPrefix("producer_test"))
+
+	for i := 0; i < 20; i++ {
+		producer := NewProducer(context.Background(), db, &workQueue, ProducerOptions{
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+		})
+		for j := 0; j < 25; j++ {
+			producer.AddItem(NewItem([]byte("race")))
+		}
+		within(t, 3*time.Second, "Flush and Close", func() {
+			var wait sync.WaitGroup
+			wait.Add(2)
+			go func() {
+				defer wait.Done()
+				if err := producer.Flush(); err != nil {
+					t.Error(err)
+				}
+			}()
+			go func() {
+				defer wait.Done()
+				if err := producer.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+			wait.Wait()
+		})
+	}
+	if server.pushed.Load() != 20*25 {
+		t.Errorf("%d items pushed, expected %d", server.pushed.Load(), 20*25)
+	}
+}
+
+func TestProducerBackgroundError(t *testing.T) {
+	server, db := newFakeRedis(t)
+	workQueue := NewWorkQueue(KeyPrefix("producer_test"))
+	producer := NewProducer(context.Background(), db, &workQueue, ProducerOptions{
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+	})
+
+	// Fail a batch flushed in the background, when nothing is listening for it.
+	server.failing.Store(true)
+	producer.AddItem(NewItem([]byte("fail")))
+	time.Sleep(100 * time.Millisecond)
+	server.failing.Store(false)
+
+	producer.AddItem(NewItem([]byte("succeed")))
+	if producer.Flush() == nil {
+		t.Error("Flush didn't return the background error")
+	}
+	if server.pushed.Load() != 1 {
+		t.Error("item added after the error not pushed")
+	}
+
+	// Once reported, the error isn't returned again.
+	producer.AddItem(NewItem([]byte("succeed")))
+	if err := producer.Flush(); err != nil {
+		t.Error("Flush returned an error already reported:", err)
+	}
+	if server.pushed.Load() != 2 {
+		t.Error("item added after Flush not pushed")
+	}
+
+	// An error since the last Flush is returned by Close.
+	server.failing.Store(true)
+	producer.AddItem(NewItem([]byte("fail")))
+	time.Sleep(100 * time.Millisecond)
+	server.failing.Store(false)
+	if producer.Close() == nil {
+		t.Error("Close didn't return the background error")
+	}
+}
+
+func TestProducerCloseTwice(t *testing.T) {
+	_, db := newFakeRedis(t)
+	workQueue := NewWorkQueue(KeyPrefix("producer_test"))
+	producer := NewProducer(context.Background(), db, &workQueue, ProducerOptions{})
+	producer.AddItem(NewItem([]byte("close")))
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := producer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
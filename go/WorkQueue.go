@@ -25,6 +25,13 @@
 //	// ...
 //	err = workQueue.AddItem(ctx, db, jsonItem)
 //
+// To add many items quickly, a [Producer] buffers items and adds them in pipelined batches:
+//
+//	producer := workqueue.NewProducer(ctx, db, &workQueue, workqueue.ProducerOptions{})
+//	producer.AddItem(exampleItem)
+//	// ...
+//	err = producer.Close()
+//
 // # Completing work
 //
 // Please read the documentation on leasing and completing items at
@@ -62,10 +69,25 @@
 //	       // (5 second) lease expires.
 //	   }
 //	}
+//
+// # Cleaning
+//
+// Items whose lease expires are only returned to the queue by a cleaner. A single process (not
+// every worker) should periodically call [WorkQueue.LightClean]:
+//
+//	for (;;) {
+//	   _, err := workQueue.LightClean(ctx, db)
+//	   if err != nil { panic(err) }
+//	   time.Sleep(time.Second)
+//	}
+//
+// Item data can also be left behind if a worker dies while completing an item. These are deleted by
+// [WorkQueue.DeepClean], which should be called much less often (every few hours).
 package workqueue
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -82,6 +104,8 @@ type WorkQueue struct {
 	mainQueueKey string
 	/// processingKey is the key for the list of items being processed
 	processingKey string
+	// cleaningKey is the key for the list of items being cleaned
+	cleaningKey string
 	// leaseKey is the  key prefix for lease entries
 	leaseKey KeyPrefix
 	// itemDataKey is the key prefix for item data
@@ -93,6 +117,7 @@ func NewWorkQueue(name KeyPrefix) WorkQueue {
 		session:       uuid.NewString(),
 		mainQueueKey:  name.Of(":queue"),
 		processingKey: name.Of(":processing"),
+		cleaningKey:   name.Of(":cleaning"),
 		leaseKey:      name.Concat(":leased_by_session:"),
 		itemDataKey:   name.Concat(":item:"),
 	}
@@ -120,6 +145,47 @@ func (workQueue *WorkQueue) AddItem(ctx context.Context, db *redis.Client, item
 	return err
 }
 
+// addItemIfAbsentScript adds an item, setting its data (KEYS[1]) and pushing its ID onto the main
+// queue (KEYS[2]), only if the ID isn't already in the main queue, processing list (KEYS[3]) or
+// cleaning list (KEYS[4]).
+var addItemIfAbsentScript = redis.NewScript(`
+if redis.call('LPOS', KEYS[2], ARGV[2])
+	or redis.call('LPOS', KEYS[3], ARGV[2])
+	or redis.call('LPOS', KEYS[4], ARGV[2]) then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('LPUSH', KEYS[2], ARGV[2])
+return 1
+`)
+
+// AddItemIfAbsent adds an item to the work queue, unless an item with the same ID is already queued
+// or being processed. This makes adding an item with a caller-supplied ID (see [NewItemWithID])
+// idempotent, so it's safe to retry.
+//
+// Any data left behind for the ID (see [WorkQueue.DeepClean]) is overwritten. As with
+// [WorkQueue.AddItem], the ID shouldn't be added again while the previous item is being completed.
+//
+// Checking for the ID searches the main queue and processing list, so AddItemIfAbsent gets slower
+// as the work queue gets longer, unlike [WorkQueue.AddItem].
+//
+// It returns true if the item was added, or false if the item was already in the work queue.
+func (workQueue *WorkQueue) AddItemIfAbsent(ctx context.Context, db *redis.Client, item Item) (bool, error) {
+	added, err := addItemIfAbsentScript.Run(
+		ctx,
+		db,
+		[]string{
+			workQueue.itemDataKey.Of(item.ID),
+			workQueue.mainQueueKey,
+			workQueue.processingKey,
+			workQueue.cleaningKey,
+		},
+		item.Data,
+		item.ID,
+	).Int()
+	return added == 1, err
+}
+
 // Return the length of the work queue (not including items being processed, see
 // [WorkQueue.Processing]).
 func (workQueue *WorkQueue) QueueLen(ctx context.Context, db *redis.Client) (int64, error) {
@@ -131,6 +197,153 @@ func (workQueue *WorkQueue) Processing(ctx context.Context, db *redis.Client) (i
 	return db.LLen(ctx, workQueue.processingKey).Result()
 }
 
+// LightClean returns items, whose lease has expired, from the processing list back to the main
+// queue, so other workers can pick them up. This should be called periodically by a single cleaner
+// (not by every worker).
+//
+// It returns the number of items which were returned to the main queue.
+func (workQueue *WorkQueue) LightClean(ctx context.Context, db *redis.Client) (int, error) {
+	reset := 0
+	processing, err := db.LRange(ctx, workQueue.processingKey, 0, -1).Result()
+	if err != nil {
+		return reset, err
+	}
+	for _, itemId := range processing {
+		// If the lease key is not present for an item (it expired or was never created because the
+		// client crashed before creating it) then move the item back to the main queue so others
+		// can work on it.
+		leaseExists, err := workQueue.leaseExists(ctx, db, itemId)
+		if err != nil {
+			return reset, err
+		}
+		if leaseExists {
+			continue
+		}
+		// While working on an item, we store it in the cleaning list. If we ever crash, we come
+		// back and check these items.
+		if err := db.LPush(ctx, workQueue.cleaningKey, itemId).Err(); err != nil {
+			return reset, err
+		}
+		removed, err := db.LRem(ctx, workQueue.processingKey, 0, itemId).Result()
+		if err != nil {
+			return reset, err
+		}
+		if removed > 0 {
+			if err := db.LPush(ctx, workQueue.mainQueueKey, itemId).Err(); err != nil {
+				return reset, err
+			}
+			reset++
+		}
+		if err := db.LRem(ctx, workQueue.cleaningKey, 0, itemId).Err(); err != nil {
+			return reset, err
+		}
+	}
+
+	// Now we check the items a previous clean may have forgotten about.
+	forgot, err := db.LRange(ctx, workQueue.cleaningKey, 0, -1).Result()
+	if err != nil {
+		return reset, err
+	}
+	for _, itemId := range forgot {
+		lost, err := workQueue.isLost(ctx, db, itemId)
+		if err != nil {
+			return reset, err
+		}
+		if lost {
+			// FIXME: this introduces a race, what if the job has been completed?
+			if err := db.LPush(ctx, workQueue.mainQueueKey, itemId).Err(); err != nil {
+				return reset, err
+			}
+			reset++
+		}
+		if err := db.LRem(ctx, workQueue.cleaningKey, 0, itemId).Err(); err != nil {
+			return reset, err
+		}
+	}
+	return reset, nil
+}
+
+// leaseExists returns true iff a lease on itemId exists.
+func (workQueue *WorkQueue) leaseExists(ctx context.Context, db *redis.Client, itemId string) (bool, error) {
+	exists, err := db.Exists(ctx, workQueue.leaseKey.Of(itemId)).Result()
+	return exists != 0, err
+}
+
+// isLost returns true iff itemId has no lease and is in neither the main queue nor the processing
+// list.
+func (workQueue *WorkQueue) isLost(ctx context.Context, db *redis.Client, itemId string) (bool, error) {
+	leaseExists, err := workQueue.leaseExists(ctx, db, itemId)
+	if leaseExists || err != nil {
+		return false, err
+	}
+	for _, key := range []string{workQueue.mainQueueKey, workQueue.processingKey} {
+		err := db.LPos(ctx, key, itemId, redis.LPosArgs{}).Err()
+		if err == nil {
+			return false, nil
+		} else if err != redis.Nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// deepCleanScript deletes the data (KEYS[2*i+2]) and lease (KEYS[2*i+3]) keys for each item ID in
+// ARGV which isn't in the main queue (KEYS[1]), processing list (KEYS[2]) or cleaning list (KEYS[3]).
+// It returns the number of items whose keys were deleted.
+var deepCleanScript = redis.NewScript(`
+local deleted = 0
+for i, id in ipairs(ARGV) do
+	if not redis.call('LPOS', KEYS[1], id)
+		and not redis.call('LPOS', KEYS[2], id)
+		and not redis.call('LPOS', KEYS[3], id) then
+		redis.call('DEL', KEYS[2*i+2], KEYS[2*i+3])
+		deleted = deleted + 1
+	end
+end
+return deleted
+`)
+
+// DeepClean deletes item data, and leases, left behind by items which are no longer in the work
+// queue. This happens if a worker dies part way through [WorkQueue.Complete]. It's very rare, so
+// DeepClean should be run automatically but infrequently, by a single cleaner.
+//
+// Since it scans every item in the work queue, DeepClean is much slower than
+// [WorkQueue.LightClean]. [WorkQueue.AddItem] sets an item's data just before adding its ID to the
+// queue, so a DeepClean running at that exact moment can delete the data of a new item. Running it
+// infrequently keeps this very unlikely.
+//
+// It returns the number of items whose keys were deleted.
+func (workQueue *WorkQueue) DeepClean(ctx context.Context, db *redis.Client) (int, error) {
+	deleted := 0
+	var cursor uint64
+	for {
+		dataKeys, nextCursor, err := db.Scan(ctx, cursor, workQueue.itemDataKey.Of("*"), 100).Result()
+		if err != nil {
+			return deleted, err
+		}
+		if len(dataKeys) > 0 {
+			keys := []string{workQueue.mainQueueKey, workQueue.processingKey, workQueue.cleaningKey}
+			itemIds := make([]any, len(dataKeys))
+			for i, dataKey := range dataKeys {
+				itemId := strings.TrimPrefix(dataKey, string(workQueue.itemDataKey))
+				keys = append(keys, dataKey, workQueue.leaseKey.Of(itemId))
+				itemIds[i] = itemId
+			}
+			// Checking and deleting in a script means an item can't be leased or returned to the
+			// queue in between.
+			n, err := deepCleanScript.Run(ctx, db, keys, itemIds...).Int()
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+		}
+		cursor = nextCursor
+		if cursor == 0 {
+			return deleted, nil
+		}
+	}
+}
+
 // Request a work lease the work queue. This should be called by a worker to get work to complete.
 // When completed, the [WorkQueue.Complete] method should be called.
 //
@@ -204,3 +417,47 @@ func (workQueue *WorkQueue) Complete(ctx context.Context, db *redis.Client, item
 	})
 	return true, err
 }
+
+// completeManyScript removes each item ID in ARGV from the processing list (KEYS[1]) and, only if it
+// was removed, deletes its data (KEYS[2*i]) and lease (KEYS[2*i+1]) keys. It returns 1 for each item
+// that was removed, and 0 for the others.
+var completeManyScript = redis.NewScript(`
+local completed = {}
+for i, id in ipairs(ARGV) do
+	if redis.call('LREM', KEYS[1], 0, id) > 0 then
+		redis.call('DEL', KEYS[2*i], KEYS[2*i+1])
+		completed[i] = 1
+	else
+		completed[i] = 0
+	end
+end
+return completed
+`)
+
+// CompleteMany marks many jobs as completed, and removes them from the work queue, in a single round
+// trip, rather than calling [WorkQueue.Complete] for each item.
+//
+// The result has one entry per item, with the same meaning as the result of [WorkQueue.Complete].
+func (workQueue *WorkQueue) CompleteMany(ctx context.Context, db *redis.Client, items []*Item) ([]bool, error) {
+	completed := make([]bool, len(items))
+	if len(items) == 0 {
+		return completed, nil
+	}
+	keys := make([]string, 1, 1+2*len(items))
+	keys[0] = workQueue.processingKey
+	itemIds := make([]any, len(items))
+	for i, item := range items {
+		keys = append(keys, workQueue.itemDataKey.Of(item.ID), workQueue.leaseKey.Of(item.ID))
+		itemIds[i] = item.ID
+	}
+	// Removing and deleting in one script means the item data and lease can't be left behind if
+	// something fails in between.
+	removed, err := completeManyScript.Run(ctx, db, keys, itemIds...).Int64Slice()
+	if err != nil {
+		return completed, err
+	}
+	for i := range completed {
+		completed[i] = removed[i] == 1
+	}
+	return completed, nil
+}
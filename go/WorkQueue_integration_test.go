@@ -0,0 +1,323 @@
+//go:build integration
+
+package workqueue
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func init() {
+	defaultTestHost = "localhost:6379"
+}
+
+func TestLifecycle(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	type Test struct {
+		N uint `json:"n"`
+	}
+	added := make(map[string]Test)
+	for n := uint(0); n < 3; n++ {
+		item := unwrap(NewItemFromJSONData(Test{n}))
+		if err := workQueue.AddItem(ctx, db, item); err != nil {
+			t.Fatal(err)
+		}
+		added[item.ID] = Test{n}
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 3 {
+		t.Error("queue length not 3 after adding items")
+	}
+
+	for i := 0; i < 3; i++ {
+		item := unwrap(workQueue.Lease(ctx, db, true, time.Second, time.Minute))
+		if item == nil {
+			t.Fatal("failed to lease item")
+		}
+		if unwrap(ItemDataJson[Test](item)) != added[item.ID] {
+			t.Error("leased item data doesn't match added data")
+		}
+		if unwrap(workQueue.Processing(ctx, db)) != 1 {
+			t.Error("leased item not processing")
+		}
+		if !unwrap(workQueue.Complete(ctx, db, item)) {
+			t.Error("first complete returned false")
+		}
+		if unwrap(workQueue.Complete(ctx, db, item)) {
+			t.Error("second complete returned true")
+		}
+		if db.Exists(ctx, workQueue.itemDataKey.Of(item.ID), workQueue.leaseKey.Of(item.ID)).Val() != 0 {
+			t.Error("item data or lease not deleted on complete")
+		}
+		delete(added, item.ID)
+	}
+	if len(added) != 0 {
+		t.Error("not all items were leased")
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 0 || unwrap(workQueue.Processing(ctx, db)) != 0 {
+		t.Error("work queue not empty after completing all items")
+	}
+
+	start := time.Now()
+	if unwrap(workQueue.Lease(ctx, db, true, time.Second, time.Minute)) != nil {
+		t.Error("blocking lease on an empty queue returned an item")
+	}
+	if time.Since(start) < time.Second {
+		t.Error("blocking lease returned before the timeout")
+	}
+	if unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute)) != nil {
+		t.Error("non-blocking lease on an empty queue returned an item")
+	}
+}
+
+func TestAddItemIfAbsent(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	if !unwrap(workQueue.AddItemIfAbsent(ctx, db, NewItemWithID("foo", []byte("first")))) {
+		t.Error("new item not added")
+	}
+	if unwrap(workQueue.AddItemIfAbsent(ctx, db, NewItemWithID("foo", []byte("second")))) {
+		t.Error("item added twice")
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 1 {
+		t.Error("queue length not 1 after adding the same item twice")
+	}
+
+	item := unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute))
+	if item == nil || item.ID != "foo" || string(item.Data) != "first" {
+		t.Fatal("first item data not leased")
+	}
+	// While the item is being processed, it's still not added again.
+	if unwrap(workQueue.AddItemIfAbsent(ctx, db, NewItemWithID("foo", []byte("third")))) {
+		t.Error("item added again while processing")
+	}
+	if !unwrap(workQueue.Complete(ctx, db, item)) {
+		t.Error("complete returned false")
+	}
+	// Once it's completed, it can be added again.
+	if !unwrap(workQueue.AddItemIfAbsent(ctx, db, NewItemWithID("foo", []byte("fourth")))) {
+		t.Error("item not added again after completing")
+	}
+
+	// Data left behind for an ID, not in the work queue, doesn't stop it being added.
+	db.Set(ctx, workQueue.itemDataKey.Of("bar"), "left behind", 0)
+	if !unwrap(workQueue.AddItemIfAbsent(ctx, db, NewItemWithID("bar", []byte("new")))) {
+		t.Error("item with left behind data not added")
+	}
+	if db.Get(ctx, workQueue.itemDataKey.Of("bar")).Val() != "new" {
+		t.Error("left behind data not overwritten")
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	// Another worker, with its own session, on the same queue.
+	otherWorkQueue := workQueue
+	otherWorkQueue.session = "other-session"
+	ctx := context.Background()
+
+	added := NewItem([]byte("expiring"))
+	if err := workQueue.AddItem(ctx, db, added); err != nil {
+		t.Fatal(err)
+	}
+	first := unwrap(workQueue.Lease(ctx, db, false, 0, time.Second))
+	if first == nil || first.ID != added.ID {
+		t.Fatal("failed to lease item")
+	}
+	if unwrap(otherWorkQueue.Lease(ctx, db, false, 0, time.Minute)) != nil {
+		t.Error("leased item given to another worker before the lease expired")
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if unwrap(workQueue.LightClean(ctx, db)) != 1 {
+		t.Error("expired item not reset")
+	}
+	second := unwrap(otherWorkQueue.Lease(ctx, db, false, 0, time.Minute))
+	if second == nil || second.ID != added.ID || !bytes.Equal(second.Data, added.Data) {
+		t.Fatal("expired item not leased by another worker")
+	}
+	if db.Get(ctx, workQueue.leaseKey.Of(added.ID)).Val() != otherWorkQueue.session {
+		t.Error("lease not held by the other worker")
+	}
+
+	// Either worker can complete the item, but only the first to do so is told it completed it.
+	if !unwrap(workQueue.Complete(ctx, db, first)) {
+		t.Error("first complete returned false")
+	}
+	if unwrap(otherWorkQueue.Complete(ctx, db, second)) {
+		t.Error("second complete returned true")
+	}
+}
+
+func TestCompleteMany(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		if err := workQueue.AddItem(ctx, db, NewItem([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items := make([]*Item, 4)
+	for i := range items {
+		items[i] = unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute))
+	}
+	if !unwrap(workQueue.Complete(ctx, db, items[1])) {
+		t.Error("complete returned false")
+	}
+
+	completed := unwrap(workQueue.CompleteMany(ctx, db, items))
+	expected := []bool{true, false, true, true}
+	for i := range expected {
+		if completed[i] != expected[i] {
+			t.Errorf("CompleteMany returned %v, expected %v", completed, expected)
+			break
+		}
+	}
+	if unwrap(workQueue.Processing(ctx, db)) != 0 {
+		t.Error("items still processing after CompleteMany")
+	}
+	for _, item := range items {
+		if db.Exists(ctx, workQueue.itemDataKey.Of(item.ID), workQueue.leaseKey.Of(item.ID)).Val() != 0 {
+			t.Error("item data or lease not deleted by CompleteMany")
+		}
+	}
+	if len(unwrap(workQueue.CompleteMany(ctx, db, nil))) != 0 {
+		t.Error("CompleteMany of no items returned results")
+	}
+}
+
+func TestLightClean(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := workQueue.AddItem(ctx, db, NewItem([]byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expiring := unwrap(workQueue.Lease(ctx, db, false, 0, time.Second))
+	held := unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute))
+	if expiring == nil || held == nil {
+		t.Fatal("failed to lease items")
+	}
+
+	if unwrap(workQueue.LightClean(ctx, db)) != 0 {
+		t.Error("items reset before their leases expired")
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if unwrap(workQueue.LightClean(ctx, db)) != 1 {
+		t.Error("expired item not reset")
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 1 {
+		t.Error("expired item not returned to the queue")
+	}
+	if unwrap(workQueue.Processing(ctx, db)) != 1 {
+		t.Error("held item not still processing")
+	}
+
+	item := unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute))
+	if item == nil || item.ID != expiring.ID {
+		t.Error("expired item not leased again")
+	}
+}
+
+func TestDeepClean(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	queued := NewItem([]byte("queued"))
+	if err := workQueue.AddItem(ctx, db, queued); err != nil {
+		t.Fatal(err)
+	}
+	processing := NewItem([]byte("processing"))
+	if err := workQueue.AddItem(ctx, db, processing); err != nil {
+		t.Fatal(err)
+	}
+	if unwrap(workQueue.Lease(ctx, db, false, 0, time.Minute)) == nil {
+		t.Fatal("failed to lease item")
+	}
+	// Leave data and a lease behind, as if a worker died part way through completing an item.
+	orphan := NewItem([]byte("orphan"))
+	db.Set(ctx, workQueue.itemDataKey.Of(orphan.ID), orphan.Data, 0)
+	db.Set(ctx, workQueue.leaseKey.Of(orphan.ID), "dead-session", time.Minute)
+
+	if unwrap(workQueue.DeepClean(ctx, db)) != 1 {
+		t.Error("orphaned item not cleaned")
+	}
+	if db.Exists(ctx, workQueue.itemDataKey.Of(orphan.ID), workQueue.leaseKey.Of(orphan.ID)).Val() != 0 {
+		t.Error("orphaned item data or lease not deleted")
+	}
+	if db.Exists(ctx, workQueue.itemDataKey.Of(queued.ID), workQueue.itemDataKey.Of(processing.ID)).Val() != 2 {
+		t.Error("data deleted for items still in the work queue")
+	}
+	if unwrap(workQueue.DeepClean(ctx, db)) != 0 {
+		t.Error("second deep clean deleted items")
+	}
+}
+
+func TestProducer(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	delivered := 0
+	producer := NewProducer(ctx, db, &workQueue, ProducerOptions{
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+		OnDelivery: func(items []Item, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			delivered += len(items)
+		},
+	})
+	for i := 0; i < 250; i++ {
+		producer.AddItem(NewItem([]byte{byte(i)}))
+	}
+	if err := producer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 250 {
+		t.Error("not all items added by Flush")
+	}
+	for i := 0; i < 10; i++ {
+		producer.AddItem(NewItem([]byte{byte(i)}))
+	}
+	if err := producer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if unwrap(workQueue.QueueLen(ctx, db)) != 260 {
+		t.Error("not all items added by Close")
+	}
+	if delivered != 260 {
+		t.Errorf("%d items delivered, expected 260", delivered)
+	}
+}
+
+func TestProducerFlushInterval(t *testing.T) {
+	db := testDB(t)
+	workQueue := testWorkQueue(t, db)
+	ctx := context.Background()
+
+	producer := NewProducer(ctx, db, &workQueue, ProducerOptions{
+		BatchSize:     100,
+		FlushInterval: 50 * time.Millisecond,
+	})
+	defer producer.Close()
+	producer.AddItem(NewItem([]byte("interval")))
+	time.Sleep(200 * time.Millisecond)
+	if unwrap(workQueue.QueueLen(ctx, db)) != 1 {
+		t.Error("item not added after the flush interval")
+	}
+}
@@ -0,0 +1,257 @@
+package workqueue
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultTestHost is the redis server used by testDB when REDIS_WORK_QUEUE_TEST_HOST isn't set. It's
+// only set when building with the integration tag, so the benchmarks are skipped by default, but
+// the integration tests never are.
+var defaultTestHost string
+
+// testDB connects to the redis server given by the REDIS_WORK_QUEUE_TEST_HOST environment variable,
+// or defaultTestHost. The test or benchmark is skipped if neither is set, since these must run
+// against a real redis server.
+//
+// To start a redis server without persistence, you can run:
+//
+//	echo -e 'save ""\nappendonly no' | redis-server -
+func testDB(tb testing.TB) *redis.Client {
+	host := os.Getenv("REDIS_WORK_QUEUE_TEST_HOST")
+	if host == "" {
+		host = defaultTestHost
+	}
+	if host == "" {
+		tb.Skip("REDIS_WORK_QUEUE_TEST_HOST not set")
+	}
+	db := redis.NewClient(&redis.Options{
+		Addr: host,
+	})
+	tb.Cleanup(func() { db.Close() })
+	if err := db.Ping(context.Background()).Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return db
+}
+
+// testWorkQueue creates a work queue with a random name, and removes all of its keys when the test
+// or benchmark finishes.
+func testWorkQueue(tb testing.TB, db *redis.Client) WorkQueue {
+	name := KeyPrefix("test_work_queue:" + uuid.NewString())
+	tb.Cleanup(func() {
+		ctx := context.Background()
+		iter := db.Scan(ctx, 0, name.Of("*"), 0).Iterator()
+		for iter.Next(ctx) {
+			db.Del(ctx, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			tb.Error(err)
+		}
+	})
+	return NewWorkQueue(name)
+}
+
+// reportOpsPerSec adds an ops/s metric to the benchmark results.
+func reportOpsPerSec(b *testing.B) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "ops/s")
+}
+
+// fillWorkQueue adds n items to the work queue, in a single pipeline.
+func fillWorkQueue(b *testing.B, db *redis.Client, workQueue *WorkQueue, n int) {
+	ctx := context.Background()
+	pipeline := db.Pipeline()
+	for i := 0; i < n; i++ {
+		workQueue.AddItemToPipeline(ctx, pipeline, NewItem([]byte("benchmark")))
+	}
+	if _, err := pipeline.Exec(ctx); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// leaseAll adds n items to the work queue and leases them all.
+func leaseAll(b *testing.B, db *redis.Client, workQueue *WorkQueue, n int) []*Item {
+	ctx := context.Background()
+	fillWorkQueue(b, db, workQueue, n)
+	items := make([]*Item, n)
+	for i := range items {
+		item, err := workQueue.Lease(ctx, db, false, 0, time.Minute)
+		if err != nil {
+			b.Fatal(err)
+		}
+		items[i] = item
+	}
+	return items
+}
+
+func BenchmarkAddItem(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	item := NewItem([]byte("benchmark"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := workQueue.AddItem(ctx, db, item); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+// BenchmarkAddItemIfAbsent adds a new item per iteration, so every call takes the insert path. The
+// queue grows as it runs, and AddItemIfAbsent searches it, so ns/op depends on b.N.
+func BenchmarkAddItemIfAbsent(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	items := make([]Item, b.N)
+	for i := range items {
+		items[i] = NewItemWithID(NewSortableID(), []byte("benchmark"))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, item := range items {
+		added, err := workQueue.AddItemIfAbsent(ctx, db, item)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if !added {
+			b.Fatal("item not added")
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+func BenchmarkAddItemPipelined(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	item := NewItem([]byte("benchmark"))
+	const batchSize = 100
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	pipeline := db.Pipeline()
+	for i := 0; i < b.N; i++ {
+		workQueue.AddItemToPipeline(ctx, pipeline, item)
+		if pipeline.Len() >= 2*batchSize || i == b.N-1 {
+			if _, err := pipeline.Exec(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+func BenchmarkProducer(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	item := NewItem([]byte("benchmark"))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	producer := NewProducer(context.Background(), db, &workQueue, ProducerOptions{})
+	for i := 0; i < b.N; i++ {
+		producer.AddItem(item)
+	}
+	if err := producer.Close(); err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+func BenchmarkLease(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	fillWorkQueue(b, db, &workQueue, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		item, err := workQueue.Lease(ctx, db, false, 0, time.Minute)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if item == nil {
+			b.Fatal("work queue empty")
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+func BenchmarkComplete(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	items := leaseAll(b, db, &workQueue, b.N)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for _, item := range items {
+		if _, err := workQueue.Complete(ctx, db, item); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+func BenchmarkCompleteMany(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+	items := leaseAll(b, db, &workQueue, b.N)
+	const batchSize = 100
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for start := 0; start < len(items); start += batchSize {
+		end := start + batchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		if _, err := workQueue.CompleteMany(ctx, db, items[start:end]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}
+
+// BenchmarkLifecycle adds, leases and completes one item per iteration.
+func BenchmarkLifecycle(b *testing.B) {
+	db := testDB(b)
+	workQueue := testWorkQueue(b, db)
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := workQueue.AddItem(ctx, db, NewItem([]byte("benchmark"))); err != nil {
+			b.Fatal(err)
+		}
+		item, err := workQueue.Lease(ctx, db, false, 0, time.Minute)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := workQueue.Complete(ctx, db, item); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	reportOpsPerSec(b)
+}